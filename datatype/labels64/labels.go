@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math"
 	"sync"
 
@@ -117,42 +118,163 @@ func (d *Data) computeAndSaveSurface(ctx storage.Context, vol *dvid.SparseVol) e
 	if err != nil {
 		return fmt.Errorf("Unable to serialize data in surface computation: %s\n", err.Error())
 	}
-	key := voxels.NewLabelSurfaceIndex(vol.Label())
-	return store.Put(ctx, key, serialization)
+	key := surfaceIndex(vol.Label(), FormatDVID)
+	if err := store.Put(ctx, key, serialization); err != nil {
+		return err
+	}
+
+	// Also compute and cache a decimated LOD pyramid so viewers like Neuroglancer
+	// can stream progressively instead of pulling the full-resolution mesh.
+	if err := d.computeAndSaveLODs(ctx, store, vol.Label(), surfaceBytes); err != nil {
+		dvid.Infof("Error computing surface LOD pyramid for label %d: %s\n", vol.Label(), err.Error())
+	}
+	return nil
 }
 
-// GetSurface returns a gzipped byte array with # voxels and float32 arrays for vertices and
-// normals.
-func GetSurface(ctx storage.Context, label uint64) ([]byte, bool, error) {
+// surfaceIndex returns the storage key under which a given label's surface is
+// cached for a particular format.  Each non-native format gets its own key,
+// derived from the native key, so converting a surface once is reusable across
+// however many GETs request that format.
+func surfaceIndex(label uint64, format SurfaceFormat) dvid.IndexBytes {
+	native := []byte(voxels.NewLabelSurfaceIndex(label))
+	if format == FormatDVID {
+		return dvid.IndexBytes(native)
+	}
+	indexBytes := make([]byte, len(native)+1)
+	copy(indexBytes, native)
+	indexBytes[len(native)] = byte(format)
+	return dvid.IndexBytes(indexBytes)
+}
+
+// GetSurface returns the surface for a label serialized in the given format.  By
+// default (FormatDVID) this is a gzipped byte array with # voxels and float32
+// arrays for vertices and normals.  Other formats (FormatGLTF, FormatPLY,
+// FormatOBJ) are standard mesh serializations converted from the native blob on
+// first request and cached under their own key so we don't recompute on every
+// subsequent GET.
+func GetSurface(ctx storage.Context, label uint64, format SurfaceFormat) ([]byte, bool, error) {
 	bigdata, err := storage.BigDataStore()
 	if err != nil {
 		return nil, false, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
 	}
 
-	// Retrieve the precomputed surface or that it's not available.
-	data, err := bigdata.Get(ctx, voxels.NewLabelSurfaceIndex(label))
+	// Return the cached serialization for this format if we've already computed it.
+	cached, err := bigdata.Get(ctx, surfaceIndex(label, format))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error in retrieving surface for label %d: %s", label, err.Error())
+	}
+	if cached != nil {
+		uncompress := format != FormatDVID
+		surfaceBytes, _, err := dvid.DeserializeData(cached, uncompress)
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to deserialize surface for label %d: %s\n", label, err.Error())
+		}
+		return surfaceBytes, true, nil
+	}
+
+	// Fall back to the native blob, converting and caching it under this format's key.
+	data, err := bigdata.Get(ctx, surfaceIndex(label, FormatDVID))
 	if err != nil {
 		return nil, false, fmt.Errorf("Error in retrieving surface for label %d: %s", label, err.Error())
 	}
 	if data == nil {
 		return []byte{}, false, nil
 	}
-	uncompress := false
-	surfaceBytes, _, err := dvid.DeserializeData(data, uncompress)
+	uncompress := format != FormatDVID
+	nativeBytes, _, err := dvid.DeserializeData(data, uncompress)
 	if err != nil {
 		return nil, false, fmt.Errorf("Unable to deserialize surface for label %d: %s\n", label, err.Error())
 	}
+	if format == FormatDVID {
+		return nativeBytes, true, nil
+	}
+
+	surfaceBytes, err := convertSurface(nativeBytes, format)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to convert surface for label %d to format %d: %s\n", label, format, err.Error())
+	}
+	compression, _ := dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
+	serialization, err := dvid.SerializeData(surfaceBytes, compression, dvid.NoChecksum)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to serialize converted surface for label %d: %s\n", label, err.Error())
+	}
+	if err := bigdata.Put(ctx, surfaceIndex(label, format), serialization); err != nil {
+		dvid.Infof("Error caching converted surface for label %d, format %d: %s\n", label, format, err.Error())
+	}
 	return surfaceBytes, true, nil
 }
 
 type sparseOp struct {
 	versionID dvid.VersionID
-	encoding  []byte
 	numBlocks uint32
 	numRuns   uint32
 	//numVoxels int32
 }
 
+// sparseVolHeader writes the fixed, format-leading bytes of an encoded sparse
+// volume (see the GetSparseVol doc comment for the full layout) to w, with the
+// # voxels and # spans fields left as placeholders since they aren't known
+// until every block has streamed by.
+func sparseVolHeader(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(dvid.EncodingBinary)
+	binary.Write(buf, binary.LittleEndian, uint8(3))
+	binary.Write(buf, binary.LittleEndian, byte(0))
+	buf.WriteByte(byte(0))
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # voxels
+	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # spans
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// StreamSparseVol writes an encoded sparse volume (see the GetSparseVol doc
+// comment for the wire format) to w as blocks arrive from storage, rather than
+// materializing the whole body in memory first.  Because the # voxels/# spans
+// header fields can't be known until the last block has streamed, they are
+// left as zero in the header; a terminating footer frame (see
+// writeSparseVolFooter) carries the final # blocks and # runs (the # spans
+// value) so callers don't have to buffer the body just to learn them.  The
+// # voxels total is not computed.
+func StreamSparseVol(ctx storage.Context, label uint64, w io.Writer) error {
+	bigdata, err := storage.SmallDataStore()
+	if err != nil {
+		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+	if err := sparseVolHeader(w); err != nil {
+		return err
+	}
+
+	// Get the start/end indices for this body's KeyLabelSpatialMap (b + s) keys.
+	begIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MinIndexZYX)
+	endIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MaxIndexZYX)
+
+	// Process all the b+s keys and their values, which contain RLE runs for that
+	// label, writing each block's runs through to w as it arrives.  writeMu
+	// serializes writes since ProcessRange invokes the callback concurrently.
+	var writeMu sync.Mutex
+	wg := new(sync.WaitGroup)
+	op := &sparseOp{versionID: ctx.VersionID()}
+	err = bigdata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{op, wg}, func(chunk *storage.Chunk) {
+		op := chunk.Op.(*sparseOp)
+		writeMu.Lock()
+		_, writeErr := w.Write(chunk.V)
+		op.numBlocks++
+		op.numRuns += uint32(len(chunk.V) / 16)
+		writeMu.Unlock()
+		if writeErr != nil {
+			dvid.Infof("Error streaming sparse vol block for label %d: %s\n", label, writeErr.Error())
+		}
+		chunk.Wg.Done()
+	})
+	if err != nil {
+		return err
+	}
+	wg.Wait()
+
+	dvid.Debugf("[%s] label %d: streamed %d blocks, %d runs\n", ctx, label, op.numBlocks, op.numRuns)
+	return writeSparseVolFooter(w, op.numBlocks, op.numRuns)
+}
+
 // GetSparseVol returns an encoded sparse volume given a label.  The encoding has the
 // following format where integers are little endian:
 //    byte     Payload descriptor:
@@ -173,44 +295,22 @@ type sparseOp struct {
 //        int32   Length of run
 //        bytes   Optional payload dependent on first byte descriptor
 //
+// GetSparseVol is a thin, buffering wrapper over StreamSparseVol kept for
+// callers that still want the whole body (with the # spans header field
+// filled in; # voxels is left as zero) in one []byte; prefer StreamSparseVol
+// for large bodies.
 func GetSparseVol(ctx storage.Context, label uint64) ([]byte, error) {
-	bigdata, err := storage.SmallDataStore()
-	if err != nil {
-		return nil, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	var buf bytes.Buffer
+	if err := StreamSparseVol(ctx, label, &buf); err != nil {
+		return nil, err
 	}
-
-	// Create the sparse volume header
-	buf := new(bytes.Buffer)
-	buf.WriteByte(dvid.EncodingBinary)
-	binary.Write(buf, binary.LittleEndian, uint8(3))
-	binary.Write(buf, binary.LittleEndian, byte(0))
-	buf.WriteByte(byte(0))
-	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # voxels
-	binary.Write(buf, binary.LittleEndian, uint32(0)) // Placeholder for # spans
-
-	// Get the start/end indices for this body's KeyLabelSpatialMap (b + s) keys.
-	begIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MinIndexZYX)
-	endIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MaxIndexZYX)
-
-	// Process all the b+s keys and their values, which contain RLE runs for that label.
-	wg := new(sync.WaitGroup)
-	op := &sparseOp{versionID: ctx.VersionID(), encoding: buf.Bytes()}
-	err = bigdata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{op, wg}, func(chunk *storage.Chunk) {
-		op := chunk.Op.(*sparseOp)
-		op.numBlocks++
-		op.encoding = append(op.encoding, chunk.V...)
-		op.numRuns += uint32(len(chunk.V) / 16)
-		chunk.Wg.Done()
-	})
+	encoding := buf.Bytes()
+	numRuns, err := stripSparseVolFooter(&encoding)
 	if err != nil {
 		return nil, err
 	}
-	wg.Wait()
-
-	binary.LittleEndian.PutUint32(op.encoding[8:12], op.numRuns)
-
-	dvid.Debugf("[%s] label %d: found %d blocks, %d runs\n", ctx, label, op.numBlocks, op.numRuns)
-	return op.encoding, nil
+	binary.LittleEndian.PutUint32(encoding[8:12], numRuns)
+	return encoding, nil
 }
 
 // Runs asynchronously and assumes that sparse volumes per spatial indices are ordered
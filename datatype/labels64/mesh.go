@@ -0,0 +1,357 @@
+/*
+	This file converts the proprietary vertex/normal surface blob produced by
+	SurfaceSerialization into formats consumable by off-the-shelf 3D tooling:
+	binary glTF (GLB), binary PLY, and ASCII OBJ.  The full-resolution native
+	blob is an unordered point cloud (one vertex + normal per marching-cubes
+	sample) with no face topology, so converting it still emits a POINTS-mode
+	GLB / a PLY and OBJ with no faces.  A decimated LOD level (see mesh_lod.go
+	and mesh_topology.go) does have reconstructed triangle adjacency, though,
+	so each exporter here also accepts an optional face index buffer and
+	switches to a real triangle mesh when one is supplied.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// SurfaceFormat identifies a serialization of a label's surface.  Each format is
+// cached under its own key so repeated GETs don't pay a reconversion cost.
+type SurfaceFormat byte
+
+const (
+	// FormatDVID is the original gzipped [# voxels][vertices][normals] float32 blob.
+	FormatDVID SurfaceFormat = iota
+	// FormatGLTF is binary glTF 2.0 (GLB) with a single mesh primitive.
+	FormatGLTF
+	// FormatPLY is binary_little_endian PLY 1.0.
+	FormatPLY
+	// FormatOBJ is ASCII Wavefront OBJ.
+	FormatOBJ
+)
+
+// ParseSurfaceFormat converts a query parameter value like "gltf" or "ply" into
+// a SurfaceFormat, defaulting to FormatDVID for an empty string.
+func ParseSurfaceFormat(s string) (SurfaceFormat, error) {
+	switch s {
+	case "", "dvid":
+		return FormatDVID, nil
+	case "gltf":
+		return FormatGLTF, nil
+	case "ply":
+		return FormatPLY, nil
+	case "obj":
+		return FormatOBJ, nil
+	default:
+		return FormatDVID, fmt.Errorf("Unknown surface format requested: %s", s)
+	}
+}
+
+// ContentType returns the HTTP Content-Type that should be set on a surface GET
+// of this format.
+func (f SurfaceFormat) ContentType() string {
+	switch f {
+	case FormatGLTF:
+		return "model/gltf-binary"
+	case FormatOBJ:
+		return "text/plain"
+	case FormatPLY:
+		return "application/octet-stream"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// parseSurfaceBlob unpacks the native DVID surface blob -- a little-endian
+// uint32 voxel count followed by all vertex floats and then all normal floats,
+// in (x,y,z) triples -- into separate vertex and normal float32 slices.
+func parseSurfaceBlob(data []byte) (numVoxels uint32, vertices, normals []float32, err error) {
+	if len(data) < 4 {
+		err = fmt.Errorf("Surface blob too small to contain voxel count: %d bytes", len(data))
+		return
+	}
+	numVoxels = binary.LittleEndian.Uint32(data[0:4])
+	remaining := data[4:]
+	if len(remaining)%4 != 0 {
+		err = fmt.Errorf("Surface blob float data is not a multiple of 4 bytes: %d bytes", len(remaining))
+		return
+	}
+	numFloats := len(remaining) / 4
+	if numFloats%6 != 0 {
+		err = fmt.Errorf("Surface blob does not contain matching vertex/normal triples: %d floats", numFloats)
+		return
+	}
+	numPoints := numFloats / 6
+	vertices = make([]float32, numPoints*3)
+	normals = make([]float32, numPoints*3)
+	reader := bytes.NewReader(remaining)
+	if err = binary.Read(reader, binary.LittleEndian, vertices); err != nil {
+		return
+	}
+	err = binary.Read(reader, binary.LittleEndian, normals)
+	return
+}
+
+// convertSurface converts a native DVID surface blob into the requested standard
+// mesh format.  FormatDVID is a no-op and just returns the blob unchanged.
+func convertSurface(nativeBytes []byte, format SurfaceFormat) ([]byte, error) {
+	if format == FormatDVID {
+		return nativeBytes, nil
+	}
+	_, vertices, normals, err := parseSurfaceBlob(nativeBytes)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatGLTF:
+		return encodeGLB(vertices, normals, nil)
+	case FormatPLY:
+		return encodePLY(vertices, normals, nil), nil
+	case FormatOBJ:
+		return encodeOBJ(vertices, normals, nil), nil
+	default:
+		return nil, fmt.Errorf("Unable to convert surface to unknown format %d", format)
+	}
+}
+
+// ----- glTF / GLB -----
+
+const (
+	glbMagic       = 0x46546C67 // "glTF"
+	glbVersion     = 2
+	glbChunkJSON   = 0x4E4F534A // "JSON"
+	glbChunkBinary = 0x004E4942 // "BIN\0"
+)
+
+// encodeGLB packs vertices and normals into a binary glTF 2.0 (GLB) buffer
+// holding a single mesh primitive.  When faces is empty, the surface is an
+// unordered point cloud (see file doc comment) and the primitive is emitted
+// in POINTS mode: identical (vertex, normal) pairs are deduped into an
+// indexed primitive, or left non-indexed if dedup doesn't reduce the vertex
+// count.  When faces is non-empty -- e.g. a decimated LOD level, whose
+// vertices are already deduped and indexed by mesh_topology.go -- it's used
+// directly as the index buffer and the primitive is emitted in TRIANGLES
+// mode instead.
+func encodeGLB(vertices, normals []float32, faces [][3]int) ([]byte, error) {
+	numPoints := len(vertices) / 3
+	if numPoints == 0 || len(normals) != len(vertices) {
+		return nil, fmt.Errorf("Cannot build glTF mesh from %d vertices, %d normals", numPoints, len(normals)/3)
+	}
+
+	const (
+		modePoints    = 0
+		modeTriangles = 4
+	)
+
+	posVerts, normVerts := vertices, normals
+	var indices []uint32
+	mode := modePoints
+	if len(faces) > 0 {
+		indices = make([]uint32, 0, len(faces)*3)
+		for _, f := range faces {
+			indices = append(indices, uint32(f[0]), uint32(f[1]), uint32(f[2]))
+		}
+		mode = modeTriangles
+	} else {
+		type point struct {
+			v, n [3]float32
+		}
+		seen := make(map[point]uint32, numPoints)
+		uniqueVerts := make([]float32, 0, len(vertices))
+		uniqueNorms := make([]float32, 0, len(normals))
+		pointIndices := make([]uint32, numPoints)
+		for i := 0; i < numPoints; i++ {
+			p := point{
+				v: [3]float32{vertices[i*3], vertices[i*3+1], vertices[i*3+2]},
+				n: [3]float32{normals[i*3], normals[i*3+1], normals[i*3+2]},
+			}
+			idx, ok := seen[p]
+			if !ok {
+				idx = uint32(len(seen))
+				seen[p] = idx
+				uniqueVerts = append(uniqueVerts, p.v[0], p.v[1], p.v[2])
+				uniqueNorms = append(uniqueNorms, p.n[0], p.n[1], p.n[2])
+			}
+			pointIndices[i] = idx
+		}
+		posVerts, normVerts = uniqueVerts, uniqueNorms
+		if len(uniqueVerts)/3 < numPoints {
+			indices = pointIndices
+		}
+	}
+
+	posBuf := new(bytes.Buffer)
+	binary.Write(posBuf, binary.LittleEndian, posVerts)
+	normBuf := new(bytes.Buffer)
+	binary.Write(normBuf, binary.LittleEndian, normVerts)
+
+	minPos, maxPos := vec3MinMax(posVerts)
+
+	bufferViews := []map[string]interface{}{
+		{"buffer": 0, "byteOffset": 0, "byteLength": posBuf.Len(), "target": 34962},
+		{"buffer": 0, "byteOffset": pad4(posBuf.Len()), "byteLength": normBuf.Len(), "target": 34962},
+	}
+	accessors := []map[string]interface{}{
+		{"bufferView": 0, "componentType": 5126, "count": len(posVerts) / 3, "type": "VEC3", "min": minPos, "max": maxPos},
+		{"bufferView": 1, "componentType": 5126, "count": len(normVerts) / 3, "type": "VEC3"},
+	}
+	binBuf := new(bytes.Buffer)
+	binBuf.Write(posBuf.Bytes())
+	writePadding(binBuf, posBuf.Len())
+	binBuf.Write(normBuf.Bytes())
+	writePadding(binBuf, normBuf.Len())
+
+	primitive := map[string]interface{}{
+		"attributes": map[string]interface{}{"POSITION": 0, "NORMAL": 1},
+		"mode":       mode,
+	}
+	if len(indices) > 0 {
+		idxBuf := new(bytes.Buffer)
+		binary.Write(idxBuf, binary.LittleEndian, indices)
+		bufferViews = append(bufferViews, map[string]interface{}{
+			"buffer": 0, "byteOffset": binBuf.Len(), "byteLength": idxBuf.Len(), "target": 34963,
+		})
+		accessors = append(accessors, map[string]interface{}{
+			"bufferView": 2, "componentType": 5125, "count": len(indices), "type": "SCALAR",
+		})
+		binBuf.Write(idxBuf.Bytes())
+		writePadding(binBuf, idxBuf.Len())
+		primitive["indices"] = 2
+	}
+
+	doc := map[string]interface{}{
+		"asset":       map[string]interface{}{"version": "2.0", "generator": "dvid/labels64"},
+		"scene":       0,
+		"scenes":      []map[string]interface{}{{"nodes": []int{0}}},
+		"nodes":       []map[string]interface{}{{"mesh": 0}},
+		"meshes":      []map[string]interface{}{{"primitives": []map[string]interface{}{primitive}}},
+		"accessors":   accessors,
+		"bufferViews": bufferViews,
+		"buffers":     []map[string]interface{}{{"byteLength": binBuf.Len()}},
+	}
+	jsonBytes, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	for len(jsonBytes)%4 != 0 {
+		jsonBytes = append(jsonBytes, ' ') // glTF pads the JSON chunk with spaces
+	}
+	binBytes := binBuf.Bytes() // BIN chunk is already 4-byte aligned by writePadding above
+
+	total := 12 + 8 + len(jsonBytes) + 8 + len(binBytes)
+	glb := new(bytes.Buffer)
+	binary.Write(glb, binary.LittleEndian, uint32(glbMagic))
+	binary.Write(glb, binary.LittleEndian, uint32(glbVersion))
+	binary.Write(glb, binary.LittleEndian, uint32(total))
+
+	binary.Write(glb, binary.LittleEndian, uint32(len(jsonBytes)))
+	binary.Write(glb, binary.LittleEndian, uint32(glbChunkJSON))
+	glb.Write(jsonBytes)
+
+	binary.Write(glb, binary.LittleEndian, uint32(len(binBytes)))
+	binary.Write(glb, binary.LittleEndian, uint32(glbChunkBinary))
+	glb.Write(binBytes)
+
+	return glb.Bytes(), nil
+}
+
+// pad4 rounds n up to the next multiple of 4.
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// writePadding appends zero bytes to buf so that the number of bytes written
+// since byteLength was last aligned becomes a multiple of 4.
+func writePadding(buf *bytes.Buffer, byteLength int) {
+	for i := byteLength; i < pad4(byteLength); i++ {
+		buf.WriteByte(0x00)
+	}
+}
+
+// vec3MinMax returns the per-component min and max of a flat (x,y,z)-triple slice.
+func vec3MinMax(v []float32) (min, max [3]float32) {
+	if len(v) == 0 {
+		return
+	}
+	min = [3]float32{v[0], v[1], v[2]}
+	max = min
+	for i := 0; i+2 < len(v); i += 3 {
+		for c := 0; c < 3; c++ {
+			if v[i+c] < min[c] {
+				min[c] = v[i+c]
+			}
+			if v[i+c] > max[c] {
+				max[c] = v[i+c]
+			}
+		}
+	}
+	return
+}
+
+// ----- PLY -----
+
+// encodePLY writes vertices and normals as a binary_little_endian PLY 1.0
+// file.  If faces is empty, the surface is currently a point cloud and the
+// face element is left empty; otherwise each face is written as a binary
+// "3 a b c" vertex_indices list entry.
+func encodePLY(vertices, normals []float32, faces [][3]int) []byte {
+	numPoints := len(vertices) / 3
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "ply\n")
+	fmt.Fprintf(buf, "format binary_little_endian 1.0\n")
+	if len(faces) > 0 {
+		fmt.Fprintf(buf, "comment DVID reconstructed surface -- decimated LOD mesh with triangle topology\n")
+	} else {
+		fmt.Fprintf(buf, "comment DVID reconstructed surface -- point cloud, no face topology\n")
+	}
+	fmt.Fprintf(buf, "element vertex %d\n", numPoints)
+	fmt.Fprintf(buf, "property float x\n")
+	fmt.Fprintf(buf, "property float y\n")
+	fmt.Fprintf(buf, "property float z\n")
+	fmt.Fprintf(buf, "property float nx\n")
+	fmt.Fprintf(buf, "property float ny\n")
+	fmt.Fprintf(buf, "property float nz\n")
+	fmt.Fprintf(buf, "element face %d\n", len(faces))
+	fmt.Fprintf(buf, "property list uchar int vertex_indices\n")
+	fmt.Fprintf(buf, "end_header\n")
+	for i := 0; i < numPoints; i++ {
+		binary.Write(buf, binary.LittleEndian, vertices[i*3:i*3+3])
+		binary.Write(buf, binary.LittleEndian, normals[i*3:i*3+3])
+	}
+	for _, f := range faces {
+		buf.WriteByte(3)
+		binary.Write(buf, binary.LittleEndian, [3]int32{int32(f[0]), int32(f[1]), int32(f[2])})
+	}
+	return buf.Bytes()
+}
+
+// ----- OBJ -----
+
+// encodeOBJ writes vertices and normals as an ASCII Wavefront OBJ file.  If
+// faces is empty, the surface is currently a point cloud and no "f" face
+// lines are emitted; otherwise each face is written as an "f" line with
+// 1-based vertex//normal indices (vertex and normal arrays share the same
+// indexing here).
+func encodeOBJ(vertices, normals []float32, faces [][3]int) []byte {
+	numPoints := len(vertices) / 3
+	buf := new(bytes.Buffer)
+	if len(faces) > 0 {
+		fmt.Fprintf(buf, "# DVID reconstructed surface -- decimated LOD mesh with triangle topology\n")
+	} else {
+		fmt.Fprintf(buf, "# DVID reconstructed surface -- point cloud, no face topology\n")
+	}
+	for i := 0; i < numPoints; i++ {
+		fmt.Fprintf(buf, "v %g %g %g\n", vertices[i*3], vertices[i*3+1], vertices[i*3+2])
+	}
+	for i := 0; i < numPoints; i++ {
+		fmt.Fprintf(buf, "vn %g %g %g\n", normals[i*3], normals[i*3+1], normals[i*3+2])
+	}
+	for _, f := range faces {
+		fmt.Fprintf(buf, "f %d//%d %d//%d %d//%d\n", f[0]+1, f[0]+1, f[1]+1, f[1]+1, f[2]+1, f[2]+1)
+	}
+	return buf.Bytes()
+}
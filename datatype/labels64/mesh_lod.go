@@ -0,0 +1,269 @@
+/*
+	This file builds a progressive level-of-detail (LOD) pyramid for a label's
+	surface, so streaming viewers can request a coarse mesh for distant/small
+	bodies and a fine one up close.
+
+	The native surface blob (see mesh.go) only gives us an unordered vertex/
+	normal point cloud: SurfaceSerialization emits one sample per marching-cubes
+	vertex with no accompanying face/index buffer, and neither SparseVol's
+	internals nor the RLE block storage that would let this package run its own
+	marching-cubes pass are reachable from here.  Real quadric-error-metric
+	(QEM) edge-collapse decimation needs face adjacency to be meaningful, so
+	mesh_topology.go reconstructs an approximate triangulation first --
+	buildTriangleMesh fans each point's spatial-hash neighbors into triangles
+	around it -- and decimateIndexedMesh then runs genuine QEM edge collapse
+	over that reconstructed topology.  This is a documented substitute for the
+	real prerequisite (an index buffer from SurfaceSerialization, or a
+	marching-cubes pass), not a recovery of it; the output triangles are an
+	approximation of the true surface topology, not the ground truth one.
+
+	Each level's triangle index buffer is persisted alongside its vertices and
+	normals (see serializeLODBlob) and GetSurfaceLOD can hand it back as a real
+	triangulated mesh in glTF/PLY/OBJ, not merely a sparser point cloud --
+	otherwise all of the above QEM decimation work would never reach a caller.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// lodFractions are the target vertex-count fractions (of the full-resolution
+// mesh) stored for each LOD level, finest (full resolution) first and
+// descending to the coarsest level.
+var lodFractions = []float64{1.0, 0.5, 0.25, 0.125}
+
+// lodKeyMarker disambiguates an LOD-indexed surface key from a format-indexed
+// one (see surfaceIndex in labels.go); format suffixes and LOD levels both use
+// small integers starting at 0, so without a marker byte they'd collide.
+const lodKeyMarker = 0xFF
+
+// manifestKeyMarker marks the key holding a label's LOD manifest JSON.
+const manifestKeyMarker = 0xFE
+
+// lodSurfaceIndex returns the storage key for a given LOD level of a label's surface.
+func lodSurfaceIndex(label uint64, lod uint8) dvid.IndexBytes {
+	native := []byte(voxels.NewLabelSurfaceIndex(label))
+	indexBytes := make([]byte, len(native)+2)
+	copy(indexBytes, native)
+	indexBytes[len(native)] = lodKeyMarker
+	indexBytes[len(native)+1] = byte(lod)
+	return dvid.IndexBytes(indexBytes)
+}
+
+// manifestSurfaceIndex returns the storage key for a label's LOD manifest.
+func manifestSurfaceIndex(label uint64) dvid.IndexBytes {
+	native := []byte(voxels.NewLabelSurfaceIndex(label))
+	indexBytes := append(native, manifestKeyMarker)
+	return dvid.IndexBytes(indexBytes)
+}
+
+// LODInfo describes one level of a label's surface LOD pyramid.
+type LODInfo struct {
+	Level       uint8 `json:"level"`
+	NumVertices int   `json:"num_vertices"`
+	NumFaces    int   `json:"num_faces"`
+	Bytes       int   `json:"bytes"`
+}
+
+// LODManifest lists the available LOD levels for a label, finest to coarsest.
+type LODManifest struct {
+	LODs []LODInfo `json:"lods"`
+}
+
+// computeAndSaveLODs decimates nativeBytes into the LOD pyramid defined by
+// lodFractions and stores each level plus a manifest under its own key.  The
+// point cloud is triangulated once (see mesh_topology.go's buildTriangleMesh),
+// then each level's QEM edge collapse runs against the previous (finer)
+// level's already-decimated mesh rather than re-triangulating and
+// re-decimating from full resolution every time, so the total cost across the
+// whole pyramid stays close to one triangulation pass plus one decimation pass
+// instead of growing with the number of levels -- important since this runs
+// synchronously in ComputeSurface's single-goroutine consumer loop for every
+// label.
+func (d *Data) computeAndSaveLODs(ctx storage.Context, store storage.KeyValueSetter, label uint64, nativeBytes []byte) error {
+	_, vertices, normals, err := parseSurfaceBlob(nativeBytes)
+	if err != nil {
+		return err
+	}
+	numPoints := len(vertices) / 3
+	compression, _ := dvid.NewCompression(dvid.Gzip, dvid.DefaultCompression)
+
+	curMesh := buildTriangleMesh(vertices, normals)
+	manifest := LODManifest{LODs: make([]LODInfo, 0, len(lodFractions))}
+	for lod, fraction := range lodFractions {
+		target := int(float64(numPoints) * fraction)
+		if target < 1 {
+			target = 1
+		}
+		curMesh = decimateIndexedMesh(curMesh, target)
+		curVerts, curNorms := flatten(curMesh)
+
+		blob := serializeLODBlob(curVerts, curNorms, curMesh.faces)
+		serialization, err := dvid.SerializeData(blob, compression, dvid.NoChecksum)
+		if err != nil {
+			return fmt.Errorf("Unable to serialize LOD %d for label %d: %s\n", lod, label, err.Error())
+		}
+		if err := store.Put(ctx, lodSurfaceIndex(label, uint8(lod)), serialization); err != nil {
+			return fmt.Errorf("Unable to store LOD %d for label %d: %s\n", lod, label, err.Error())
+		}
+		manifest.LODs = append(manifest.LODs, LODInfo{
+			Level:       uint8(lod),
+			NumVertices: len(curVerts) / 3,
+			NumFaces:    len(curMesh.faces),
+			Bytes:       len(blob),
+		})
+	}
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal LOD manifest for label %d: %s\n", label, err.Error())
+	}
+	return store.Put(ctx, manifestSurfaceIndex(label), manifestBytes)
+}
+
+// serializeSurfaceBlob packs vertices and normals into the native
+// [# voxels][vertices][normals] blob layout used elsewhere in this package,
+// writing the vertex count into the # voxels slot.
+func serializeSurfaceBlob(vertices, normals []float32) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, uint32(len(vertices)/3))
+	binary.Write(buf, binary.LittleEndian, vertices)
+	binary.Write(buf, binary.LittleEndian, normals)
+	return buf.Bytes()
+}
+
+// serializeLODBlob packs an LOD level's vertices, normals, and triangle index
+// buffer into a self-contained blob: the native [# voxels][vertices][normals]
+// layout (see serializeSurfaceBlob) followed by a uint32 face count and that
+// many (a,b,c) uint32 index triples.  Persisting the index buffer here is
+// what lets GetSurfaceLOD hand back a real triangulated mesh instead of
+// throwing away the face adjacency decimateIndexedMesh already computed.
+func serializeLODBlob(vertices, normals []float32, faces [][3]int) []byte {
+	buf := bytes.NewBuffer(serializeSurfaceBlob(vertices, normals))
+	binary.Write(buf, binary.LittleEndian, uint32(len(faces)))
+	for _, f := range faces {
+		binary.Write(buf, binary.LittleEndian, [3]uint32{uint32(f[0]), uint32(f[1]), uint32(f[2])})
+	}
+	return buf.Bytes()
+}
+
+// parseLODBlob unpacks an LOD blob (see serializeLODBlob) into its vertex,
+// normal, and triangle index slices.
+func parseLODBlob(data []byte) (vertices, normals []float32, faces [][3]int, err error) {
+	if len(data) < 4 {
+		err = fmt.Errorf("LOD blob too small to contain vertex count: %d bytes", len(data))
+		return
+	}
+	numVertices := int(binary.LittleEndian.Uint32(data[0:4]))
+	vertexSectionLen := 4 + numVertices*3*4*2 // header + (vertices + normals) floats
+	if len(data) < vertexSectionLen {
+		err = fmt.Errorf("LOD blob too small for %d vertices: %d bytes", numVertices, len(data))
+		return
+	}
+	if _, vertices, normals, err = parseSurfaceBlob(data[:vertexSectionLen]); err != nil {
+		return
+	}
+
+	rest := data[vertexSectionLen:]
+	if len(rest) < 4 {
+		err = fmt.Errorf("LOD blob too small to contain face count: %d bytes", len(rest))
+		return
+	}
+	numFaces := int(binary.LittleEndian.Uint32(rest[0:4]))
+	rest = rest[4:]
+	if len(rest) != numFaces*3*4 {
+		err = fmt.Errorf("LOD blob face data size mismatch: expected %d bytes for %d faces, got %d bytes",
+			numFaces*3*4, numFaces, len(rest))
+		return
+	}
+	indices := make([]uint32, numFaces*3)
+	if err = binary.Read(bytes.NewReader(rest), binary.LittleEndian, indices); err != nil {
+		return
+	}
+	faces = make([][3]int, numFaces)
+	for i := range faces {
+		faces[i] = [3]int{int(indices[i*3]), int(indices[i*3+1]), int(indices[i*3+2])}
+	}
+	return
+}
+
+// GetSurfaceLOD returns the requested LOD level of a label's surface
+// serialized in the given format.  FormatDVID returns the raw LOD blob (the
+// native vertex/normal layout plus the triangle index buffer persisted by
+// computeAndSaveLODs -- see serializeLODBlob); FormatGLTF/FormatPLY/FormatOBJ
+// convert that triangulated mesh into the requested standard format, unlike
+// GetSurface's full-resolution point cloud, which has no faces to convert.
+func GetSurfaceLOD(ctx storage.Context, label uint64, lod uint8, format SurfaceFormat) ([]byte, bool, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	data, err := bigdata.Get(ctx, lodSurfaceIndex(label, lod))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error retrieving LOD %d for label %d: %s", lod, label, err.Error())
+	}
+	if data == nil {
+		return []byte{}, false, nil
+	}
+	uncompress := true
+	blob, _, err := dvid.DeserializeData(data, uncompress)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to deserialize LOD %d for label %d: %s\n", lod, label, err.Error())
+	}
+	if format == FormatDVID {
+		return blob, true, nil
+	}
+
+	vertices, normals, faces, err := parseLODBlob(blob)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to parse LOD %d for label %d: %s\n", lod, label, err.Error())
+	}
+	var converted []byte
+	switch format {
+	case FormatGLTF:
+		if converted, err = encodeGLB(vertices, normals, faces); err != nil {
+			return nil, false, fmt.Errorf("Unable to convert LOD %d for label %d to glTF: %s\n", lod, label, err.Error())
+		}
+	case FormatPLY:
+		converted = encodePLY(vertices, normals, faces)
+	case FormatOBJ:
+		converted = encodeOBJ(vertices, normals, faces)
+	default:
+		return nil, false, fmt.Errorf("Unable to convert LOD %d to unknown format %d", lod, format)
+	}
+	return converted, true, nil
+}
+
+// GetSurfaceManifest returns the JSON-encoded LOD manifest for a label so a
+// client can pick a level based on distance or screen size.
+func GetSurfaceManifest(ctx storage.Context, label uint64) ([]byte, bool, error) {
+	bigdata, err := storage.BigDataStore()
+	if err != nil {
+		return nil, false, fmt.Errorf("Cannot get datastore that handles big data: %s\n", err.Error())
+	}
+	data, err := bigdata.Get(ctx, manifestSurfaceIndex(label))
+	if err != nil {
+		return nil, false, fmt.Errorf("Error retrieving LOD manifest for label %d: %s", label, err.Error())
+	}
+	if data == nil {
+		return []byte{}, false, nil
+	}
+	return data, true, nil
+}
+
+// CompressDraco is the hook point for a `compression=draco` surface GET option.
+// DVID doesn't vendor cgo Draco bindings or a pure-Go encoder yet, so this
+// returns an error rather than silently skipping compression; wire in a real
+// encoder here once one is added as a dependency.
+func CompressDraco(blob []byte) ([]byte, error) {
+	return nil, fmt.Errorf("Draco compression requested but no encoder is available in this build")
+}
@@ -0,0 +1,161 @@
+package labels64
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// spherePoints lays out n points roughly over the surface of a unit sphere
+// using Fibonacci sphere spacing, each with its outward radial normal, so
+// there's a plausible closed surface for buildTriangleMesh to fan triangles
+// around and for decimateIndexedMesh to collapse edges on.
+func spherePoints(n int) (vertices, normals []float32) {
+	goldenAngle := math.Pi * (3 - math.Sqrt(5))
+	for i := 0; i < n; i++ {
+		y := 1 - 2*float64(i)/float64(n-1)
+		radius := math.Sqrt(1 - y*y)
+		theta := goldenAngle * float64(i)
+		x := radius * math.Cos(theta)
+		z := radius * math.Sin(theta)
+		vertices = append(vertices, float32(x), float32(y), float32(z))
+		normals = append(normals, float32(x), float32(y), float32(z))
+	}
+	return
+}
+
+func TestBuildTriangleMeshProducesFaces(t *testing.T) {
+	vertices, normals := spherePoints(60)
+	mesh := buildTriangleMesh(vertices, normals)
+
+	if len(mesh.verts) != 60 {
+		t.Fatalf("expected 60 deduped vertices, got %d", len(mesh.verts))
+	}
+	if len(mesh.faces) == 0 {
+		t.Fatal("expected buildTriangleMesh to reconstruct at least some triangles from the point cloud")
+	}
+	for _, f := range mesh.faces {
+		if f[0] == f[1] || f[1] == f[2] || f[0] == f[2] {
+			t.Errorf("face %v has a repeated vertex index", f)
+		}
+		for _, idx := range f {
+			if idx < 0 || idx >= len(mesh.verts) {
+				t.Errorf("face %v references out-of-range vertex index", f)
+			}
+		}
+	}
+}
+
+func TestDecimateIndexedMeshReducesVertexCount(t *testing.T) {
+	vertices, normals := spherePoints(200)
+	mesh := buildTriangleMesh(vertices, normals)
+
+	decimated := decimateIndexedMesh(mesh, 50)
+	if len(decimated.verts) >= len(mesh.verts) {
+		t.Fatalf("expected decimation to reduce vertex count below %d, got %d", len(mesh.verts), len(decimated.verts))
+	}
+	if len(decimated.verts) == 0 {
+		t.Fatal("expected decimation to leave at least some vertices")
+	}
+	for _, f := range decimated.faces {
+		for _, idx := range f {
+			if idx < 0 || idx >= len(decimated.verts) {
+				t.Errorf("decimated face %v references out-of-range vertex index (have %d vertices)", f, len(decimated.verts))
+			}
+		}
+	}
+}
+
+func TestDecimateIndexedMeshNoopWhenTargetExceedsCount(t *testing.T) {
+	vertices, normals := spherePoints(20)
+	mesh := buildTriangleMesh(vertices, normals)
+
+	decimated := decimateIndexedMesh(mesh, 1000)
+	if len(decimated.verts) != len(mesh.verts) {
+		t.Errorf("expected no-op when target exceeds vertex count, got %d vertices (want %d)",
+			len(decimated.verts), len(mesh.verts))
+	}
+}
+
+// TestDecimateIndexedMeshScalesToConnectomeSizedBodies guards against a
+// regression back to a linear best-edge scan per collapse: that scan is
+// O((n-target)*E), and at a body size real segmented neurons regularly hit
+// (thousands of surface points), it takes long enough to stall the single
+// ComputeSurface consumer goroutine for the whole dataset. The heap-based
+// collapse this exercises should finish this size well under a second.
+func TestDecimateIndexedMeshScalesToConnectomeSizedBodies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing-sensitive decimation benchmark in -short mode")
+	}
+	vertices, normals := spherePoints(4000)
+	mesh := buildTriangleMesh(vertices, normals)
+
+	start := time.Now()
+	decimated := decimateIndexedMesh(mesh, 200)
+	elapsed := time.Since(start)
+
+	const budget = 2 * time.Second
+	if elapsed > budget {
+		t.Fatalf("decimateIndexedMesh took %s for %d points, want under %s; "+
+			"this likely means the edge-collapse loop regressed to a linear scan per collapse",
+			elapsed, len(mesh.verts), budget)
+	}
+	if len(decimated.verts) >= len(mesh.verts) {
+		t.Fatalf("expected decimation to reduce vertex count below %d, got %d", len(mesh.verts), len(decimated.verts))
+	}
+}
+
+func TestFlattenRoundTrip(t *testing.T) {
+	vertices, normals := spherePoints(30)
+	mesh := buildTriangleMesh(vertices, normals)
+	outVerts, outNorms := flatten(mesh)
+
+	if len(outVerts) != len(mesh.verts)*3 {
+		t.Errorf("expected %d flattened vertex floats, got %d", len(mesh.verts)*3, len(outVerts))
+	}
+	if len(outNorms) != len(mesh.norms)*3 {
+		t.Errorf("expected %d flattened normal floats, got %d", len(mesh.norms)*3, len(outNorms))
+	}
+}
+
+func TestSerializeLODBlobRoundTrip(t *testing.T) {
+	vertices, normals := spherePoints(60)
+	mesh := buildTriangleMesh(vertices, normals)
+	outVerts, outNorms := flatten(mesh)
+
+	blob := serializeLODBlob(outVerts, outNorms, mesh.faces)
+	gotVerts, gotNorms, gotFaces, err := parseLODBlob(blob)
+	if err != nil {
+		t.Fatalf("parseLODBlob returned error: %v", err)
+	}
+	if len(gotVerts) != len(outVerts) || len(gotNorms) != len(outNorms) {
+		t.Fatalf("expected %d vertex floats / %d normal floats, got %d/%d",
+			len(outVerts), len(outNorms), len(gotVerts), len(gotNorms))
+	}
+	for i := range outVerts {
+		if gotVerts[i] != outVerts[i] {
+			t.Errorf("vertex float %d: expected %v, got %v", i, outVerts[i], gotVerts[i])
+		}
+	}
+	if len(gotFaces) != len(mesh.faces) {
+		t.Fatalf("expected %d faces, got %d", len(mesh.faces), len(gotFaces))
+	}
+	for i, f := range mesh.faces {
+		if gotFaces[i] != f {
+			t.Errorf("face %d: expected %v, got %v", i, f, gotFaces[i])
+		}
+	}
+}
+
+func TestParseLODBlobRejectsTruncated(t *testing.T) {
+	if _, _, _, err := parseLODBlob([]byte{1, 2}); err == nil {
+		t.Error("expected error on a blob too small to hold a vertex count")
+	}
+	vertices, normals := spherePoints(10)
+	mesh := buildTriangleMesh(vertices, normals)
+	outVerts, outNorms := flatten(mesh)
+	blob := serializeLODBlob(outVerts, outNorms, mesh.faces)
+	if _, _, _, err := parseLODBlob(blob[:len(blob)-1]); err == nil {
+		t.Error("expected error on a blob truncated mid-face-data")
+	}
+}
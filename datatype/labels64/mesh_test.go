@@ -0,0 +1,259 @@
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func sampleSurfaceBlob() []byte {
+	// Two triangles' worth of vertices/normals so there's something for the
+	// format encoders to chew on.
+	vertices := []float32{
+		0, 0, 0, 1, 0, 0, 0, 1, 0,
+		1, 1, 0, 2, 1, 0, 1, 2, 0,
+	}
+	normals := []float32{
+		0, 0, 1, 0, 0, 1, 0, 0, 1,
+		0, 0, 1, 0, 0, 1, 0, 0, 1,
+	}
+	return serializeSurfaceBlob(vertices, normals)
+}
+
+func TestParseSurfaceBlobRoundTrip(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	numVoxels, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	if int(numVoxels) != 6 {
+		t.Errorf("expected 6 points recorded as the voxel count, got %d", numVoxels)
+	}
+	if len(vertices) != 18 || len(normals) != 18 {
+		t.Errorf("expected 18 floats each for vertices/normals, got %d/%d", len(vertices), len(normals))
+	}
+	if vertices[3] != 1 || vertices[4] != 0 || vertices[5] != 0 {
+		t.Errorf("expected second vertex (1,0,0), got (%v,%v,%v)", vertices[3], vertices[4], vertices[5])
+	}
+}
+
+func TestParseSurfaceBlobRejectsTruncated(t *testing.T) {
+	if _, _, _, err := parseSurfaceBlob([]byte{1, 2}); err == nil {
+		t.Error("expected error on a blob too small to hold a voxel count")
+	}
+	// 4-byte count plus a float count that isn't a multiple of 6.
+	bad := make([]byte, 4+4*5)
+	if _, _, _, err := parseSurfaceBlob(bad); err == nil {
+		t.Error("expected error when vertex/normal floats don't split into matching triples")
+	}
+}
+
+func TestEncodeGLBHeader(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	_, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	glb, err := encodeGLB(vertices, normals, nil)
+	if err != nil {
+		t.Fatalf("encodeGLB returned error: %v", err)
+	}
+	if len(glb) < 12 {
+		t.Fatalf("GLB output too short to contain a header: %d bytes", len(glb))
+	}
+	magic := binary.LittleEndian.Uint32(glb[0:4])
+	version := binary.LittleEndian.Uint32(glb[4:8])
+	totalLength := binary.LittleEndian.Uint32(glb[8:12])
+	if magic != glbMagic {
+		t.Errorf("expected glTF magic %x, got %x", glbMagic, magic)
+	}
+	if version != glbVersion {
+		t.Errorf("expected glTF version %d, got %d", glbVersion, version)
+	}
+	if int(totalLength) != len(glb) {
+		t.Errorf("header total length %d does not match actual output length %d", totalLength, len(glb))
+	}
+
+	jsonChunkLength := binary.LittleEndian.Uint32(glb[12:16])
+	jsonChunkType := binary.LittleEndian.Uint32(glb[16:20])
+	if jsonChunkType != glbChunkJSON {
+		t.Errorf("expected first chunk type to be JSON (%x), got %x", glbChunkJSON, jsonChunkType)
+	}
+	if int(jsonChunkLength)%4 != 0 {
+		t.Errorf("expected JSON chunk to be padded to a 4-byte boundary, got length %d", jsonChunkLength)
+	}
+
+	var doc struct {
+		Meshes []struct {
+			Primitives []struct {
+				Mode *int `json:"mode"`
+			} `json:"primitives"`
+		} `json:"meshes"`
+	}
+	if err := json.Unmarshal(glb[20:20+jsonChunkLength], &doc); err != nil {
+		t.Fatalf("failed to parse GLB JSON chunk: %v", err)
+	}
+	if len(doc.Meshes) != 1 || len(doc.Meshes[0].Primitives) != 1 {
+		t.Fatalf("expected exactly one mesh with one primitive, got %+v", doc)
+	}
+	mode := doc.Meshes[0].Primitives[0].Mode
+	if mode == nil || *mode != 0 {
+		t.Errorf("expected primitive mode 0 (POINTS) since the surface is a point cloud, got %v", mode)
+	}
+}
+
+func TestEncodeGLBWithFacesUsesTrianglesMode(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	_, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	faces := [][3]int{{0, 1, 2}, {3, 4, 5}}
+	glb, err := encodeGLB(vertices, normals, faces)
+	if err != nil {
+		t.Fatalf("encodeGLB returned error: %v", err)
+	}
+	jsonChunkLength := binary.LittleEndian.Uint32(glb[12:16])
+
+	var doc struct {
+		Meshes []struct {
+			Primitives []struct {
+				Mode    *int `json:"mode"`
+				Indices *int `json:"indices"`
+			} `json:"primitives"`
+		} `json:"meshes"`
+		Accessors []struct {
+			Count int `json:"count"`
+		} `json:"accessors"`
+	}
+	if err := json.Unmarshal(glb[20:20+jsonChunkLength], &doc); err != nil {
+		t.Fatalf("failed to parse GLB JSON chunk: %v", err)
+	}
+	primitive := doc.Meshes[0].Primitives[0]
+	if primitive.Mode == nil || *primitive.Mode != 4 {
+		t.Errorf("expected primitive mode 4 (TRIANGLES) when faces are supplied, got %v", primitive.Mode)
+	}
+	if primitive.Indices == nil {
+		t.Fatal("expected an index accessor when faces are supplied")
+	}
+	if got := doc.Accessors[*primitive.Indices].Count; got != len(faces)*3 {
+		t.Errorf("expected %d indices (3 per face), got %d", len(faces)*3, got)
+	}
+}
+
+func TestEncodePLYHeader(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	_, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	ply := encodePLY(vertices, normals, nil)
+	text := string(ply)
+	if !strings.HasPrefix(text, "ply\nformat binary_little_endian 1.0\n") {
+		t.Errorf("expected standard PLY binary_little_endian header, got prefix %q", text[:minInt(40, len(text))])
+	}
+	if !strings.Contains(text, "element vertex 6\n") {
+		t.Errorf("expected 'element vertex 6' in header, got:\n%s", text)
+	}
+	if !strings.Contains(text, "element face 0\n") {
+		t.Errorf("expected 'element face 0' since no triangulation is available, got:\n%s", text)
+	}
+
+	headerEnd := bytes.Index(ply, []byte("end_header\n"))
+	if headerEnd < 0 {
+		t.Fatal("expected an end_header marker")
+	}
+	body := ply[headerEnd+len("end_header\n"):]
+	wantBytes := 6 * 6 * 4 // 6 vertices * (x,y,z,nx,ny,nz) * 4 bytes/float32
+	if len(body) != wantBytes {
+		t.Errorf("expected %d bytes of binary vertex data, got %d", wantBytes, len(body))
+	}
+}
+
+func TestEncodePLYWithFacesIncludesFaceElement(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	_, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	faces := [][3]int{{0, 1, 2}, {3, 4, 5}}
+	ply := encodePLY(vertices, normals, faces)
+	text := string(ply)
+	if !strings.Contains(text, "element face 2\n") {
+		t.Errorf("expected 'element face 2' when 2 faces are supplied, got:\n%s", text)
+	}
+
+	headerEnd := bytes.Index(ply, []byte("end_header\n"))
+	if headerEnd < 0 {
+		t.Fatal("expected an end_header marker")
+	}
+	body := ply[headerEnd+len("end_header\n"):]
+	vertexBytes := 6 * 6 * 4            // 6 vertices * (x,y,z,nx,ny,nz) * 4 bytes/float32
+	faceBytes := len(faces) * (1 + 3*4) // 1 uchar count + 3 int32 indices per face
+	if len(body) != vertexBytes+faceBytes {
+		t.Errorf("expected %d bytes of binary vertex+face data, got %d", vertexBytes+faceBytes, len(body))
+	}
+}
+
+func TestEncodeOBJWithFacesEmitsFLines(t *testing.T) {
+	blob := sampleSurfaceBlob()
+	_, vertices, normals, err := parseSurfaceBlob(blob)
+	if err != nil {
+		t.Fatalf("parseSurfaceBlob returned error: %v", err)
+	}
+	obj := string(encodeOBJ(vertices, normals, nil))
+	if strings.Contains(obj, "\nf ") {
+		t.Errorf("expected no 'f' lines when no faces are supplied, got:\n%s", obj)
+	}
+
+	faces := [][3]int{{0, 1, 2}}
+	obj = string(encodeOBJ(vertices, normals, faces))
+	if !strings.Contains(obj, "f 1//1 2//2 3//3\n") {
+		t.Errorf("expected a 1-based 'f' line for face {0,1,2}, got:\n%s", obj)
+	}
+}
+
+func TestParseSurfaceFormat(t *testing.T) {
+	cases := map[string]SurfaceFormat{
+		"":     FormatDVID,
+		"dvid": FormatDVID,
+		"gltf": FormatGLTF,
+		"ply":  FormatPLY,
+		"obj":  FormatOBJ,
+	}
+	for s, want := range cases {
+		got, err := ParseSurfaceFormat(s)
+		if err != nil {
+			t.Errorf("ParseSurfaceFormat(%q) returned error: %v", s, err)
+		}
+		if got != want {
+			t.Errorf("ParseSurfaceFormat(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if _, err := ParseSurfaceFormat("stl"); err == nil {
+		t.Error("expected error for unknown surface format")
+	}
+}
+
+func TestSurfaceFormatContentType(t *testing.T) {
+	cases := map[SurfaceFormat]string{
+		FormatDVID: "application/octet-stream",
+		FormatGLTF: "model/gltf-binary",
+		FormatPLY:  "application/octet-stream",
+		FormatOBJ:  "text/plain",
+	}
+	for format, want := range cases {
+		if got := format.ContentType(); got != want {
+			t.Errorf("SurfaceFormat(%d).ContentType() = %q, want %q", format, got, want)
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,478 @@
+/*
+	This file reconstructs approximate triangle topology from the oriented
+	point cloud SurfaceSerialization emits, so mesh_lod.go can run genuine
+	quadric-error-metric (QEM) edge-collapse decimation on real face adjacency
+	instead of an assumption about vertex ordering.
+
+	The backlog asked for this to come from either an index buffer added to
+	SparseVol.SurfaceSerialization or a marching-cubes pass over the RLE
+	volume; neither is reachable from this package (SparseVol's internals and
+	the RLE block storage aren't part of this source tree).  What we *can* do
+	with only the vertex/normal arrays GetSurface already has is reconstruct a
+	local triangulation: for each point, gather its nearby neighbors via a
+	spatial hash grid, project them onto the plane perpendicular to the
+	point's normal, sort by angle, and fan consecutive neighbors into
+	triangles around it (the same "umbrella" construction used as a
+	neighborhood step in several point-cloud surface reconstruction methods,
+	e.g. as a precursor to ball pivoting).  It's an approximation of the real
+	marching-cubes topology, not a recovery of it, but it gives QEM decimation
+	actual face adjacency to work with instead of none.
+*/
+
+package labels64
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// indexedMesh is a deduped vertex/normal list plus a triangle index buffer.
+type indexedMesh struct {
+	verts [][3]float64
+	norms [][3]float64
+	faces [][3]int
+}
+
+// gridCell identifies a cell in the spatial hash used for neighbor lookup.
+type gridCell struct {
+	x, y, z int32
+}
+
+// neighborFanK is how many nearby points are fanned into triangles around
+// each point; higher values produce denser (and slower to decimate) meshes.
+const neighborFanK = 8
+
+// buildTriangleMesh dedupes vertices by position and reconstructs an
+// approximate triangulation via per-point neighbor fans (see file doc
+// comment).  Neighbor search uses a spatial hash grid sized to roughly one
+// point per cell, keeping the whole pass close to linear in point count
+// rather than the O(n^2) a brute-force nearest-neighbor search would cost.
+func buildTriangleMesh(vertices, normals []float32) indexedMesh {
+	numPoints := len(vertices) / 3
+	if numPoints == 0 {
+		return indexedMesh{}
+	}
+
+	type vkey [3]float32
+	posToIdx := make(map[vkey]int, numPoints)
+	verts := make([][3]float64, 0, numPoints)
+	norms := make([][3]float64, 0, numPoints)
+	for p := 0; p < numPoints; p++ {
+		key := vkey{vertices[p*3], vertices[p*3+1], vertices[p*3+2]}
+		if _, ok := posToIdx[key]; ok {
+			continue
+		}
+		posToIdx[key] = len(verts)
+		verts = append(verts, [3]float64{float64(key[0]), float64(key[1]), float64(key[2])})
+		norms = append(norms, [3]float64{float64(normals[p*3]), float64(normals[p*3+1]), float64(normals[p*3+2])})
+	}
+	n := len(verts)
+	if n < 3 {
+		return indexedMesh{verts: verts, norms: norms}
+	}
+
+	cellSize := averageSpacing(verts)
+	grid := make(map[gridCell][]int, n)
+	cellOf := func(v [3]float64) gridCell {
+		return gridCell{
+			x: int32(math.Floor(v[0] / cellSize)),
+			y: int32(math.Floor(v[1] / cellSize)),
+			z: int32(math.Floor(v[2] / cellSize)),
+		}
+	}
+	for i, v := range verts {
+		c := cellOf(v)
+		grid[c] = append(grid[c], i)
+	}
+
+	faceSet := make(map[[3]int]bool)
+	var faces [][3]int
+	for i, v := range verts {
+		neighbors := nearestNeighbors(i, v, verts, grid, cellOf, neighborFanK)
+		if len(neighbors) < 2 {
+			continue
+		}
+		u, w := tangentBasis(norms[i])
+		type angled struct {
+			idx   int
+			angle float64
+		}
+		ordered := make([]angled, len(neighbors))
+		for k, j := range neighbors {
+			d := sub(verts[j], v)
+			ordered[k] = angled{j, math.Atan2(dot(d, w), dot(d, u))}
+		}
+		sort.Slice(ordered, func(a, b int) bool { return ordered[a].angle < ordered[b].angle })
+
+		for k := 0; k < len(ordered); k++ {
+			a := ordered[k].idx
+			b := ordered[(k+1)%len(ordered)].idx
+			if a == b {
+				continue
+			}
+			key := faceKey(i, a, b)
+			if faceSet[key] {
+				continue
+			}
+			faceSet[key] = true
+			faces = append(faces, [3]int{i, a, b})
+		}
+	}
+
+	return indexedMesh{verts: verts, norms: norms, faces: faces}
+}
+
+// averageSpacing estimates a typical nearest-neighbor distance for verts by
+// treating them as roughly uniformly packed in their bounding box -- the same
+// sizing used for voxel-grid filters, here used only to pick a neighbor
+// search cell size rather than to cluster points.
+func averageSpacing(verts [][3]float64) float64 {
+	if len(verts) == 0 {
+		return 1
+	}
+	min, max := verts[0], verts[0]
+	for _, v := range verts {
+		for c := 0; c < 3; c++ {
+			if v[c] < min[c] {
+				min[c] = v[c]
+			}
+			if v[c] > max[c] {
+				max[c] = v[c]
+			}
+		}
+	}
+	volume := 1.0
+	for c := 0; c < 3; c++ {
+		extent := max[c] - min[c]
+		if extent <= 0 {
+			extent = 1
+		}
+		volume *= extent
+	}
+	spacing := math.Cbrt(volume / float64(len(verts)))
+	if spacing <= 0 {
+		spacing = 1
+	}
+	return spacing
+}
+
+// nearestNeighbors returns up to k of the closest points to verts[i] (by
+// index, excluding i), searching outward through the spatial hash grid from
+// the cell containing v until enough candidates are found.
+func nearestNeighbors(i int, v [3]float64, verts [][3]float64, grid map[gridCell][]int, cellOf func([3]float64) gridCell, k int) []int {
+	center := cellOf(v)
+	var candidates []int
+	for radius := 1; radius <= 3 && len(candidates) < k+1; radius++ {
+		candidates = candidates[:0]
+		for dx := -radius; dx <= radius; dx++ {
+			for dy := -radius; dy <= radius; dy++ {
+				for dz := -radius; dz <= radius; dz++ {
+					c := gridCell{center.x + int32(dx), center.y + int32(dy), center.z + int32(dz)}
+					candidates = append(candidates, grid[c]...)
+				}
+			}
+		}
+	}
+
+	type distIdx struct {
+		idx  int
+		dist float64
+	}
+	scored := make([]distIdx, 0, len(candidates))
+	for _, j := range candidates {
+		if j == i {
+			continue
+		}
+		d := sub(verts[j], v)
+		scored = append(scored, distIdx{j, dot(d, d)})
+	}
+	sort.Slice(scored, func(a, b int) bool { return scored[a].dist < scored[b].dist })
+
+	if len(scored) > k {
+		scored = scored[:k]
+	}
+	out := make([]int, len(scored))
+	for idx, s := range scored {
+		out[idx] = s.idx
+	}
+	return out
+}
+
+// tangentBasis returns two orthonormal vectors spanning the plane
+// perpendicular to n, used to turn 3D neighbor offsets into a 2D angle for
+// sorting around a point.
+func tangentBasis(n [3]float64) (u, w [3]float64) {
+	length := math.Sqrt(dot(n, n))
+	if length < 1e-12 {
+		n = [3]float64{0, 0, 1}
+	} else {
+		n = [3]float64{n[0] / length, n[1] / length, n[2] / length}
+	}
+	ref := [3]float64{1, 0, 0}
+	if math.Abs(n[0]) > 0.9 {
+		ref = [3]float64{0, 1, 0}
+	}
+	u = cross(n, ref)
+	uLen := math.Sqrt(dot(u, u))
+	u = [3]float64{u[0] / uLen, u[1] / uLen, u[2] / uLen}
+	w = cross(n, u)
+	return
+}
+
+// faceKey returns a canonical (order-independent) key for a triangle's three
+// vertex indices, used to dedupe triangles produced by overlapping fans.
+func faceKey(a, b, c int) [3]int {
+	idx := [3]int{a, b, c}
+	sort.Ints(idx[:])
+	return idx
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func dot(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+// ----- Quadric error metric edge-collapse decimation -----
+
+// quadric is the upper triangle of the symmetric 4x4 matrix K = p*p^T for a
+// plane p = (a,b,c,d), stored as [xx,xy,xz,xw,yy,yz,yw,zz,zw,ww].
+type quadric [10]float64
+
+func planeQuadric(v0, v1, v2 [3]float64) quadric {
+	e1, e2 := sub(v1, v0), sub(v2, v0)
+	n := cross(e1, e2)
+	length := math.Sqrt(dot(n, n))
+	if length < 1e-12 {
+		return quadric{}
+	}
+	a, b, c := n[0]/length, n[1]/length, n[2]/length
+	d := -(a*v0[0] + b*v0[1] + c*v0[2])
+	return quadric{a * a, a * b, a * c, a * d, b * b, b * c, b * d, c * c, c * d, d * d}
+}
+
+func (q quadric) add(o quadric) quadric {
+	var sum quadric
+	for i := range q {
+		sum[i] = q[i] + o[i]
+	}
+	return sum
+}
+
+// errorAt returns v^T Q v for homogeneous vertex v=(x,y,z,1).
+func (q quadric) errorAt(v [3]float64) float64 {
+	x, y, z := v[0], v[1], v[2]
+	return x*x*q[0] + 2*x*y*q[1] + 2*x*z*q[2] + 2*x*q[3] +
+		y*y*q[4] + 2*y*z*q[5] + 2*y*q[6] +
+		z*z*q[7] + 2*z*q[8] +
+		q[9]
+}
+
+// optimalPosition solves for the vertex minimizing q's error by solving the
+// linear system given by the upper-left 3x3 block of Q, falling back to the
+// midpoint of a and b when that block is singular.
+func (q quadric) optimalPosition(a, b [3]float64) [3]float64 {
+	a00, a01, a02 := q[0], q[1], q[2]
+	a10, a11, a12 := q[1], q[4], q[5]
+	a20, a21, a22 := q[2], q[5], q[7]
+	b0, b1, b2 := -q[3], -q[6], -q[8]
+
+	det := a00*(a11*a22-a12*a21) - a01*(a10*a22-a12*a20) + a02*(a10*a21-a11*a20)
+	if math.Abs(det) < 1e-9 {
+		return [3]float64{(a[0] + b[0]) / 2, (a[1] + b[1]) / 2, (a[2] + b[2]) / 2}
+	}
+	invDet := 1.0 / det
+	x := invDet * (b0*(a11*a22-a12*a21) - a01*(b1*a22-a12*b2) + a02*(b1*a21-a11*b2))
+	y := invDet * (a00*(b1*a22-a12*b2) - b0*(a10*a22-a12*a20) + a02*(a10*b2-b1*a20))
+	z := invDet * (a00*(a11*b2-b1*a21) - a01*(a10*b2-b1*a20) + b0*(a10*a21-a11*a20))
+	return [3]float64{x, y, z}
+}
+
+// meshEdge identifies an edge between two vertex indices, i < j.
+type meshEdge struct {
+	i, j int
+}
+
+// edgeCollapseItem is a candidate edge collapse queued in the decimation
+// min-heap, keyed by the QEM cost computed at the time it was pushed.
+type edgeCollapseItem struct {
+	i, j int
+	cost float64
+	pos  [3]float64
+}
+
+// edgeCollapseHeap is a container/heap min-heap over edgeCollapseItem by
+// cost, letting decimateIndexedMesh pull the cheapest remaining edge in
+// O(log E) instead of rescanning every edge per collapse.  Entries go stale
+// as vertices merge; decimateIndexedMesh validates (and, if the true cost
+// has risen, lazily re-pushes) each item when it's popped rather than
+// removing stale entries eagerly.
+type edgeCollapseHeap []*edgeCollapseItem
+
+func (h edgeCollapseHeap) Len() int            { return len(h) }
+func (h edgeCollapseHeap) Less(a, b int) bool  { return h[a].cost < h[b].cost }
+func (h edgeCollapseHeap) Swap(a, b int)       { h[a], h[b] = h[b], h[a] }
+func (h *edgeCollapseHeap) Push(x interface{}) { *h = append(*h, x.(*edgeCollapseItem)) }
+func (h *edgeCollapseHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// edgeCollapseCost returns the QEM cost and optimal merged position for
+// collapsing the edge between vertices i and j, given their combined
+// quadric.
+func edgeCollapseCost(quadrics []quadric, verts [][3]float64, i, j int) (float64, [3]float64) {
+	q := quadrics[i].add(quadrics[j])
+	v := q.optimalPosition(verts[i], verts[j])
+	return q.errorAt(v), v
+}
+
+// decimateIndexedMesh reduces mesh to approximately targetVertices vertices
+// using greedy quadric-error-metric edge collapse over its real face
+// adjacency.  Faces that degenerate (collapse to a shared vertex) are dropped
+// as their incident edges disappear.
+func decimateIndexedMesh(mesh indexedMesh, targetVertices int) indexedMesh {
+	if len(mesh.verts) == 0 || targetVertices >= len(mesh.verts) || len(mesh.faces) == 0 {
+		return mesh
+	}
+	verts := append([][3]float64(nil), mesh.verts...)
+	norms := append([][3]float64(nil), mesh.norms...)
+
+	quadrics := make([]quadric, len(verts))
+	for _, f := range mesh.faces {
+		pq := planeQuadric(verts[f[0]], verts[f[1]], verts[f[2]])
+		quadrics[f[0]] = quadrics[f[0]].add(pq)
+		quadrics[f[1]] = quadrics[f[1]].add(pq)
+		quadrics[f[2]] = quadrics[f[2]].add(pq)
+	}
+
+	alive := make([]bool, len(verts))
+	for i := range alive {
+		alive[i] = true
+	}
+	numAlive := len(verts)
+	remap := make([]int, len(verts))
+	for i := range remap {
+		remap[i] = i
+	}
+	root := func(i int) int {
+		for remap[i] != i {
+			i = remap[i]
+		}
+		return i
+	}
+
+	edgeSet := make(map[meshEdge]bool)
+	for _, f := range mesh.faces {
+		for e := 0; e < 3; e++ {
+			i, j := f[e], f[(e+1)%3]
+			if i > j {
+				i, j = j, i
+			}
+			edgeSet[meshEdge{i, j}] = true
+		}
+	}
+	pq := make(edgeCollapseHeap, 0, len(edgeSet))
+	for e := range edgeSet {
+		cost, pos := edgeCollapseCost(quadrics, verts, e.i, e.j)
+		pq = append(pq, &edgeCollapseItem{i: e.i, j: e.j, cost: cost, pos: pos})
+	}
+	heap.Init(&pq)
+
+	// Greedily collapse the cheapest remaining edge, pulled from a min-heap
+	// keyed by QEM cost rather than rescanning every edge each time.  Popped
+	// entries may be stale (their endpoints already merged, or their true
+	// cost has risen since they were pushed because a neighboring collapse
+	// updated one endpoint's quadric); such entries are dropped or lazily
+	// re-pushed with a fresh cost instead of removed eagerly.
+	for numAlive > targetVertices && pq.Len() > 0 {
+		item := heap.Pop(&pq).(*edgeCollapseItem)
+		i, j := root(item.i), root(item.j)
+		if i == j || !alive[i] || !alive[j] {
+			continue // one or both endpoints already collapsed away
+		}
+		cost, pos := edgeCollapseCost(quadrics, verts, i, j)
+		if cost > item.cost+1e-9 {
+			// Cost grew stale since this was pushed; re-queue with the
+			// current cost and keep going rather than acting on it now.
+			heap.Push(&pq, &edgeCollapseItem{i: i, j: j, cost: cost, pos: pos})
+			continue
+		}
+		verts[i] = pos
+		quadrics[i] = quadrics[i].add(quadrics[j])
+		alive[j] = false
+		remap[j] = i
+		numAlive--
+	}
+
+	return collapseToMesh(verts, norms, alive, remap, mesh.faces)
+}
+
+// collapseToMesh rebuilds an indexedMesh from collapse state: surviving
+// vertices are renumbered contiguously, and faces referencing a removed
+// vertex are remapped to its surviving root (or dropped if that degenerates
+// the face).
+func collapseToMesh(verts, norms [][3]float64, alive []bool, remap []int, faces [][3]int) indexedMesh {
+	newIdx := make([]int, len(verts))
+	outVerts := make([][3]float64, 0, len(verts))
+	outNorms := make([][3]float64, 0, len(verts))
+	for i, isAlive := range alive {
+		if !isAlive {
+			continue
+		}
+		newIdx[i] = len(outVerts)
+		outVerts = append(outVerts, verts[i])
+		outNorms = append(outNorms, norms[i])
+	}
+
+	root := func(i int) int {
+		for remap[i] != i {
+			i = remap[i]
+		}
+		return i
+	}
+
+	faceSet := make(map[[3]int]bool)
+	var outFaces [][3]int
+	for _, f := range faces {
+		a, b, c := newIdx[root(f[0])], newIdx[root(f[1])], newIdx[root(f[2])]
+		if a == b || b == c || a == c {
+			continue
+		}
+		key := faceKey(a, b, c)
+		if faceSet[key] {
+			continue
+		}
+		faceSet[key] = true
+		outFaces = append(outFaces, [3]int{a, b, c})
+	}
+
+	return indexedMesh{verts: outVerts, norms: outNorms, faces: outFaces}
+}
+
+// flatten packs mesh's vertex and normal lists into the flat float32 arrays
+// the native surface blob layout (see serializeSurfaceBlob) expects.
+func flatten(mesh indexedMesh) (vertices, normals []float32) {
+	vertices = make([]float32, 0, len(mesh.verts)*3)
+	normals = make([]float32, 0, len(mesh.norms)*3)
+	for i := range mesh.verts {
+		v, n := mesh.verts[i], mesh.norms[i]
+		vertices = append(vertices, float32(v[0]), float32(v[1]), float32(v[2]))
+		normals = append(normals, float32(n[0]), float32(n[1]), float32(n[2]))
+	}
+	return
+}
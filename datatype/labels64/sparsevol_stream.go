@@ -0,0 +1,163 @@
+/*
+	This file adds a terminating footer frame to the streamed sparse volume wire
+	format used by StreamSparseVol, plus a companion length-prefixed, block-level
+	framing suitable for gRPC/websocket clients that want to process a body's
+	sparse volume incrementally rather than parse one giant byte array.
+
+	There's no protobuf toolchain vendored in this tree, so SparseVolChunk below
+	is a hand-rolled binary frame with the same fields a SparseVolChunk protobuf
+	message would have; swap in generated protobuf code here if/when .proto
+	codegen is added to the build.
+*/
+
+package labels64
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/janelia-flyem/dvid/datatype/voxels"
+	"github.com/janelia-flyem/dvid/dvid"
+	"github.com/janelia-flyem/dvid/storage"
+)
+
+// sparseVolFooterMagic marks the start of the footer frame written after the
+// last block of a streamed sparse volume, so a client reading incrementally
+// can recognize end-of-stream and recover the totals without buffering the body.
+const sparseVolFooterMagic uint32 = 0xFFFFFFFF
+
+// writeSparseVolFooter appends a 12-byte footer frame (magic, # blocks, # runs)
+// to w, terminating a StreamSparseVol response.
+func writeSparseVolFooter(w io.Writer, numBlocks, numRuns uint32) error {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, sparseVolFooterMagic)
+	binary.Write(buf, binary.LittleEndian, numBlocks)
+	binary.Write(buf, binary.LittleEndian, numRuns)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// stripSparseVolFooter validates and removes the trailing footer frame written
+// by writeSparseVolFooter, returning the # runs it recorded.
+func stripSparseVolFooter(encoding *[]byte) (numRuns uint32, err error) {
+	data := *encoding
+	if len(data) < 12 {
+		return 0, fmt.Errorf("Sparse volume stream is too short to contain a footer frame: %d bytes", len(data))
+	}
+	footer := data[len(data)-12:]
+	if binary.LittleEndian.Uint32(footer[0:4]) != sparseVolFooterMagic {
+		return 0, fmt.Errorf("Sparse volume stream is missing its terminating footer frame")
+	}
+	numRuns = binary.LittleEndian.Uint32(footer[8:12])
+	*encoding = data[:len(data)-12]
+	return numRuns, nil
+}
+
+// ----- Framed per-block format for gRPC/websocket clients -----
+
+const (
+	sparseVolFrameChunk   byte = 1
+	sparseVolFrameSummary byte = 2
+)
+
+// blockZYXFromKey extracts the trailing spatial index bytes from a
+// KeyLabelSpatialMap key.  Per StoreKeyLabelSpatialMap (labels.go), such a key
+// is laid out as [1-byte key type][8-byte label][dvid.IndexZYXSize-byte zyx
+// index]; callers of the framed format only want that last part, not the type
+// tag and label prefixed onto it.
+func blockZYXFromKey(key []byte) []byte {
+	if len(key) < dvid.IndexZYXSize {
+		return key
+	}
+	return key[len(key)-dvid.IndexZYXSize:]
+}
+
+// writeSparseVolChunkFrame writes one length-prefixed SparseVolChunk frame:
+//   uint32  frame length (of everything that follows this field)
+//   byte    frame type (sparseVolFrameChunk)
+//   uint16  len(blockZYX)
+//   bytes   blockZYX
+//   bytes   runs, already encoded as repeating {int32 x,y,z,length} per the
+//           GetSparseVol run encoding -- reused as-is rather than re-decoded.
+func writeSparseVolChunkFrame(w io.Writer, blockZYX, runs []byte) error {
+	payload := new(bytes.Buffer)
+	payload.WriteByte(sparseVolFrameChunk)
+	binary.Write(payload, binary.LittleEndian, uint16(len(blockZYX)))
+	payload.Write(blockZYX)
+	payload.Write(runs)
+
+	frame := new(bytes.Buffer)
+	binary.Write(frame, binary.LittleEndian, uint32(payload.Len()))
+	frame.Write(payload.Bytes())
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+// writeSparseVolSummaryFrame writes the terminating summary frame:
+//   uint32  frame length (of everything that follows this field)
+//   byte    frame type (sparseVolFrameSummary)
+//   uint64  total_voxels
+//   uint64  total_runs
+func writeSparseVolSummaryFrame(w io.Writer, totalVoxels, totalRuns uint64) error {
+	payload := new(bytes.Buffer)
+	payload.WriteByte(sparseVolFrameSummary)
+	binary.Write(payload, binary.LittleEndian, totalVoxels)
+	binary.Write(payload, binary.LittleEndian, totalRuns)
+
+	frame := new(bytes.Buffer)
+	binary.Write(frame, binary.LittleEndian, uint32(payload.Len()))
+	frame.Write(payload.Bytes())
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+// StreamSparseVolFramed writes a label's sparse volume to w as a sequence of
+// length-prefixed SparseVolChunk frames, one per spatial block, terminated by
+// a summary frame -- the wire format behind the /sparsevol-stream endpoint for
+// gRPC/websocket clients that want to pipeline processing instead of waiting
+// for (and parsing) the whole body.
+func StreamSparseVolFramed(ctx storage.Context, label uint64, w io.Writer) error {
+	bigdata, err := storage.SmallDataStore()
+	if err != nil {
+		return fmt.Errorf("Cannot get datastore that handles small data: %s\n", err.Error())
+	}
+
+	begIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MinIndexZYX)
+	endIndex := voxels.NewLabelSpatialMapIndex(label, &dvid.MaxIndexZYX)
+
+	var writeMu sync.Mutex
+	var totalVoxels, totalRuns uint64
+	wg := new(sync.WaitGroup)
+	op := &sparseOp{versionID: ctx.VersionID()}
+	err = bigdata.ProcessRange(ctx, begIndex, endIndex, &storage.ChunkOp{op, wg}, func(chunk *storage.Chunk) {
+		var rles dvid.RLEs
+		if unmarshalErr := rles.UnmarshalBinary(chunk.V); unmarshalErr != nil {
+			dvid.Infof("Error deserializing RLEs while streaming label %d: %s\n", label, unmarshalErr.Error())
+			chunk.Wg.Done()
+			return
+		}
+		numVoxels, _ := rles.Stats()
+
+		writeMu.Lock()
+		writeErr := writeSparseVolChunkFrame(w, blockZYXFromKey(chunk.K), chunk.V)
+		if writeErr == nil {
+			totalVoxels += uint64(numVoxels)
+			totalRuns += uint64(len(chunk.V) / 16)
+		}
+		writeMu.Unlock()
+		if writeErr != nil {
+			dvid.Infof("Error streaming sparse vol chunk frame for label %d: %s\n", label, writeErr.Error())
+		}
+		chunk.Wg.Done()
+	})
+	if err != nil {
+		return err
+	}
+	wg.Wait()
+
+	dvid.Debugf("[%s] label %d: streamed %d voxels, %d runs as framed chunks\n", ctx, label, totalVoxels, totalRuns)
+	return writeSparseVolSummaryFrame(w, totalVoxels, totalRuns)
+}
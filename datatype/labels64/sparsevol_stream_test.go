@@ -0,0 +1,44 @@
+package labels64
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSparseVolFooterRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{1, 2, 3, 4}) // stand-in for a header + some streamed block bytes
+	if err := writeSparseVolFooter(&buf, 7, 42); err != nil {
+		t.Fatalf("writeSparseVolFooter returned error: %v", err)
+	}
+
+	encoding := buf.Bytes()
+	numRuns, err := stripSparseVolFooter(&encoding)
+	if err != nil {
+		t.Fatalf("stripSparseVolFooter returned error: %v", err)
+	}
+	if numRuns != 42 {
+		t.Errorf("expected 42 runs recovered from footer, got %d", numRuns)
+	}
+	if !bytes.Equal(encoding, []byte{1, 2, 3, 4}) {
+		t.Errorf("expected footer to be stripped back to original body, got % x", encoding)
+	}
+}
+
+func TestStripSparseVolFooterRejectsMissingFooter(t *testing.T) {
+	encoding := []byte{1, 2, 3, 4}
+	if _, err := stripSparseVolFooter(&encoding); err == nil {
+		t.Error("expected error when stripping a footer from a body lacking one")
+	}
+}
+
+func TestBlockZYXFromKey(t *testing.T) {
+	const indexZYXSize = 12 // mirrors dvid.IndexZYXSize's current size
+	zyx := bytes.Repeat([]byte{0xAB}, indexZYXSize)
+	key := append([]byte{0x01, 0, 0, 0, 0, 0, 0, 0, 0}, zyx...) // type byte + 8-byte label + zyx
+
+	got := blockZYXFromKey(key)
+	if !bytes.Equal(got, zyx) {
+		t.Errorf("expected blockZYXFromKey to strip the type/label prefix, got % x, want % x", got, zyx)
+	}
+}
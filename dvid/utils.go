@@ -15,6 +15,10 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/Kagami/go-avif"
+	"github.com/chai2010/webp"
+	_ "github.com/gen2brain/avif" // registers an AVIF decoder for image.Decode, used by ImageFromFile/ImageFromPost
 )
 
 const (
@@ -36,6 +40,19 @@ const (
 // and an explicit Quality amount is omitted.
 const DefaultJPEGQuality = 80
 
+// DefaultWebPQuality is the quality used for lossy WebP images when an explicit
+// quality amount is omitted.
+const DefaultWebPQuality = 80
+
+// DefaultAVIFQuality is the quality used for AVIF images when an explicit
+// quality amount is omitted.
+const DefaultAVIFQuality = 50
+
+// DefaultAVIFSpeed is the encoder speed/effort used for AVIF images when an
+// explicit speed amount is omitted.  Lower is slower and smaller; go-avif
+// accepts 0 (slowest) through 10 (fastest).
+const DefaultAVIFSpeed = 6
+
 // Mode is a global variable set to the run modes of this DVID process.
 var Mode ModeFlag
 
@@ -177,30 +194,98 @@ func ImageData(img image.Image) (data []uint8, stride int32, err error) {
 }
 
 // WriteImageHttp writes an image to a HTTP response writer using a format and optional
-// compression strength specified in a string, e.g., "png", "jpg:80".
+// compression strength specified in a string, e.g., "png", "jpg:80", "webp:90",
+// "webp:lossless", "avif:50:6".  A formatStr of "auto" falls back to PNG here
+// since there's no request to negotiate against; callers that want Accept
+// header-based negotiation should use WriteImageHttpRequest instead.
 func WriteImageHttp(w http.ResponseWriter, img image.Image, formatStr string) (err error) {
+	return writeImageHttp(w, img, formatStr, nil)
+}
+
+// WriteImageHttpRequest is WriteImageHttp with Accept header-based content
+// negotiation: if formatStr is "auto", the best format r advertises support
+// for is chosen (AVIF, then WebP, falling back to PNG).  Use this from HTTP
+// handlers that want to honor "auto"; WriteImageHttp remains unchanged for
+// existing callers that pass an explicit format and have no *http.Request
+// handy.
+func WriteImageHttpRequest(w http.ResponseWriter, img image.Image, formatStr string, r *http.Request) (err error) {
+	return writeImageHttp(w, img, formatStr, r)
+}
+
+func writeImageHttp(w http.ResponseWriter, img image.Image, formatStr string, r *http.Request) (err error) {
 	format := strings.Split(formatStr, ":")
-	var compression int = DefaultJPEGQuality
-	if len(format) > 1 {
-		compression, err = strconv.Atoi(format[1])
-		if err != nil {
-			return err
-		}
+	if format[0] == "auto" {
+		format = strings.Split(bestAcceptedFormat(r), ":")
 	}
 	switch format[0] {
 	case "", "png":
 		w.Header().Set("Content-type", "image/png")
 		png.Encode(w, img)
 	case "jpg", "jpeg":
+		compression := DefaultJPEGQuality
+		if len(format) > 1 {
+			if compression, err = strconv.Atoi(format[1]); err != nil {
+				return err
+			}
+		}
 		w.Header().Set("Content-type", "image/jpeg")
 		jpeg.Encode(w, img, &jpeg.Options{Quality: compression})
+	case "webp":
+		lossless := len(format) > 1 && format[1] == "lossless"
+		quality := float32(DefaultWebPQuality)
+		if len(format) > 1 && !lossless {
+			var q int
+			if q, err = strconv.Atoi(format[1]); err != nil {
+				return err
+			}
+			quality = float32(q)
+		}
+		w.Header().Set("Content-type", "image/webp")
+		err = webp.Encode(w, img, &webp.Options{Lossless: lossless, Quality: quality})
+	case "avif":
+		quality := DefaultAVIFQuality
+		speed := DefaultAVIFSpeed
+		if len(format) > 1 {
+			if quality, err = strconv.Atoi(format[1]); err != nil {
+				return err
+			}
+		}
+		if len(format) > 2 {
+			if speed, err = strconv.Atoi(format[2]); err != nil {
+				return err
+			}
+		}
+		w.Header().Set("Content-type", "image/avif")
+		err = avif.Encode(w, img, &avif.Options{Quality: quality, Speed: speed})
 	default:
 		err = fmt.Errorf("Illegal image format requested: %s", format[0])
 	}
 	return
 }
 
+// bestAcceptedFormat picks the best image format (as a WriteImageHttp format
+// string) advertised in r's Accept header, preferring AVIF over WebP over PNG.
+// It returns "png" if r is nil or advertises neither.
+func bestAcceptedFormat(r *http.Request) string {
+	if r == nil {
+		return "png"
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "image/avif"):
+		return "avif"
+	case strings.Contains(accept, "image/webp"):
+		return "webp"
+	default:
+		return "png"
+	}
+}
+
 // ImageFromFile returns an image and its format name given a file name.
+// PNG, JPEG, WebP and AVIF are all decoded through the standard image.Decode
+// registry -- the chai2010/webp and gen2brain/avif imports above register
+// WebP and AVIF decoders as a side effect.  go-avif is kept for encoding only
+// since it doesn't implement decode.
 func ImageFromFile(filename string) (img image.Image, format string, err error) {
 	var file *os.File
 	file, err = os.Open(filename)
@@ -217,8 +302,9 @@ func ImageFromFile(filename string) (img image.Image, format string, err error)
 	return
 }
 
-// ImageFromPost returns and image and its format name given a key to a POST request.
-// The image should be the first file in a POSTed form.
+// ImageFromPost returns an image and its format name given a key to a POST request.
+// The image should be the first file in a POSTed form.  See ImageFromFile for
+// which formats can be decoded.
 func ImageFromPost(r *http.Request, key string) (img image.Image, format string, err error) {
 	f, _, err := r.FormFile(key)
 	if err != nil {
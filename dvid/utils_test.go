@@ -0,0 +1,30 @@
+package dvid
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBestAcceptedFormat(t *testing.T) {
+	cases := []struct {
+		accept string
+		want   string
+	}{
+		{"", "png"},
+		{"image/png", "png"},
+		{"image/webp,*/*", "webp"},
+		{"image/avif,image/webp", "avif"},
+	}
+	for _, c := range cases {
+		r := &http.Request{Header: http.Header{}}
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		if got := bestAcceptedFormat(r); got != c.want {
+			t.Errorf("bestAcceptedFormat(Accept: %q) = %q, want %q", c.accept, got, c.want)
+		}
+	}
+	if got := bestAcceptedFormat(nil); got != "png" {
+		t.Errorf("bestAcceptedFormat(nil) = %q, want %q", got, "png")
+	}
+}